@@ -0,0 +1,58 @@
+package cmd
+
+// Command represents a command that may be executed by a player or other command source. Commands
+// registered with Register are advertised to clients through Session.SendAvailableCommands.
+type Command interface {
+	// Name returns the name of the command, the way it is typed to execute it.
+	Name() string
+	// Description returns a short description of what the command does.
+	Description() string
+	// Aliases returns alternative names the command may also be executed under.
+	Aliases() []string
+	// Params returns the overloads of the command, each a list of the parameters making up that overload.
+	Params() [][]ParamInfo
+}
+
+// ParamInfo holds the metadata of a single parameter of a command overload.
+type ParamInfo struct {
+	// Name is the name of the parameter, as shown to the client.
+	Name string
+	// Value holds the zero value of the parameter, used to find its client-side parameter type.
+	Value interface{}
+	// Optional specifies if the parameter may be omitted when executing the command.
+	Optional bool
+	// Suffix is a string displayed directly after the parameter's value, such as a unit.
+	Suffix string
+}
+
+// Parameter may be implemented by a ParamInfo.Value to customise the client-side parameter type used for
+// it, such as a player or target selector.
+type Parameter interface {
+	// Type returns the client-side parameter type used to represent the value.
+	Type() string
+}
+
+// Enum may be implemented by a ParamInfo.Value to have it presented to the client as a closed list of
+// options instead of free-form input.
+type Enum interface {
+	// Type returns the name of the enum, unique among all enums registered on the server.
+	Type() string
+	// Options returns the list of options the client may choose from.
+	Options() []string
+}
+
+// commands holds every Command registered with Register, keyed by both its name and its aliases.
+var commands = map[string]Command{}
+
+// Register registers c so that it is included in Commands and sent to clients as an available command.
+func Register(c Command) {
+	commands[c.Name()] = c
+	for _, alias := range c.Aliases() {
+		commands[alias] = c
+	}
+}
+
+// Commands returns every command currently registered, keyed by both its name and its aliases.
+func Commands() map[string]Command {
+	return commands
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import "fmt"
+
+// OutputMessage is a single structured entry produced during the execution of a command. Message is a
+// translation key rather than pre-formatted text, so that a client can render it in its own locale with
+// Parameters substituted in, matching the translation support of protocol.CommandOutputMessage.
+type OutputMessage struct {
+	// Success specifies if the entry represents a successful sub-execution of the command or a failed one.
+	Success bool
+	// Message is the translation key of the entry.
+	Message string
+	// Parameters holds the values substituted into Message's translation on the client.
+	Parameters []string
+}
+
+// Output holds the result of executing a command. Rather than a plain list of printed messages, it records
+// how many sub-executions of the command succeeded and how many failed, in addition to the structured
+// OutputMessage entries produced along the way. This is important for commands such as /execute, which may
+// run their sub-command many times and are expected to report how many of those runs succeeded.
+type Output struct {
+	success, failure int
+	messages         []OutputMessage
+}
+
+// Successf records a successful sub-execution of the command, adding an OutputMessage translated
+// client-side using key with args substituted in as its parameters.
+func (o *Output) Successf(key string, args ...interface{}) {
+	o.success++
+	o.messages = append(o.messages, OutputMessage{Success: true, Message: key, Parameters: stringify(args)})
+}
+
+// Errorf records a failed sub-execution of the command, adding an OutputMessage translated client-side
+// using key with args substituted in as its parameters.
+func (o *Output) Errorf(key string, args ...interface{}) {
+	o.failure++
+	o.messages = append(o.messages, OutputMessage{Success: false, Message: key, Parameters: stringify(args)})
+}
+
+// SuccessCount returns the number of sub-executions of the command that completed successfully.
+func (o *Output) SuccessCount() int {
+	return o.success
+}
+
+// FailureCount returns the number of sub-executions of the command that failed.
+func (o *Output) FailureCount() int {
+	return o.failure
+}
+
+// Messages returns every OutputMessage recorded on the Output, in the order Successf and Errorf produced
+// them.
+func (o *Output) Messages() []OutputMessage {
+	return o.messages
+}
+
+// stringify converts args to their string representation, as Bedrock always transmits
+// CommandOutputMessage parameters as strings regardless of the underlying Go type passed to Successf or
+// Errorf.
+func stringify(args []interface{}) []string {
+	params := make([]string, len(args))
+	for i, a := range args {
+		params[i] = fmt.Sprint(a)
+	}
+	return params
+}
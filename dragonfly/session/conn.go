@@ -0,0 +1,25 @@
+package session
+
+import (
+	"net"
+
+	"github.com/sandertv/gophertunnel/minecraft/protocol/login"
+	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
+)
+
+// Conn is the abstraction of a connection that a Session reads packets from and writes packets to.
+// *minecraft.Conn satisfies this interface, but so does any connection type that is not backed by RakNet,
+// such as tcpConn, which allows a Session to be driven by a TCP connection coming from a backend proxy.
+type Conn interface {
+	// ReadPacket reads the next packet sent by the remote end of the connection, blocking until one is
+	// received or the connection is closed.
+	ReadPacket() (packet.Packet, error)
+	// WritePacket encodes pk and writes it to the remote end of the connection.
+	WritePacket(pk packet.Packet) error
+	// IdentityData returns the identity data of the player on the other end of the connection.
+	IdentityData() login.IdentityData
+	// RemoteAddr returns the remote network address of the connection.
+	RemoteAddr() net.Addr
+	// Close closes the connection. Any blocking call to ReadPacket is cancelled.
+	Close() error
+}
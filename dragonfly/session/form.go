@@ -0,0 +1,344 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
+)
+
+// Form represents a form that can be sent to a player using Session.SendForm. It knows how to encode
+// itself to the JSON schema Bedrock expects for a ModalFormRequest packet, and how to turn the raw response
+// JSON the client sends back into typed values passed to whatever callback the Form carries.
+type Form interface {
+	// marshal encodes the form to the JSON schema Bedrock expects as the data of a ModalFormRequest.
+	marshal() ([]byte, error)
+	// submit is called once with the raw response data sent back by the client. closed is true if the player
+	// closed the form without submitting it, in which case response is the client's raw payload (empty or
+	// JSON null) and carries no answer.
+	submit(response []byte, closed bool)
+}
+
+// SendForm sends a form to the player so that it is shown the next time the client is able to display one,
+// and returns the ID it was assigned. Once the client responds, the Form's own callback is invoked with the
+// response decoded into Go values.
+func (s *Session) SendForm(form Form) (id uint32) {
+	id = atomic.AddUint32(&s.formID, 1)
+
+	b, err := form.marshal()
+	if err != nil {
+		s.log.Errorf("error encoding form: %v\n", err)
+		return id
+	}
+
+	s.formMu.Lock()
+	s.forms[id] = form
+	s.formMu.Unlock()
+
+	_ = s.writePacket(&packet.ModalFormRequest{
+		FormID:   id,
+		FormData: b,
+	})
+	return id
+}
+
+// handleModalFormResponse looks up the Form registered under the response's form ID and, if found, removes
+// it from the pending map and hands the raw response to it for decoding.
+func (s *Session) handleModalFormResponse(pk *packet.ModalFormResponse) error {
+	s.formMu.Lock()
+	form, ok := s.forms[pk.FormID]
+	if ok {
+		delete(s.forms, pk.FormID)
+	}
+	s.formMu.Unlock()
+	if !ok {
+		// The client responded to a form it was never sent, or responded to one twice. Neither is an
+		// error worth propagating.
+		return nil
+	}
+	form.submit(pk.ResponseData, formClosed(pk.ResponseData))
+	return nil
+}
+
+// formClosed reports whether response represents a player closing a form without submitting it. The client
+// sends an empty payload in that case for some form types, but for others it sends the literal JSON `null`,
+// which must not be mistaken for a real response value (decoding `null` into an int or bool succeeds and
+// silently yields the zero value, which would otherwise look like a deliberate choice of the first button).
+func formClosed(response []byte) bool {
+	trimmed := bytes.TrimSpace(response)
+	return len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null"))
+}
+
+// ModalForm is a form with a title, a body of text and two buttons, used to ask the player to confirm or
+// deny a choice.
+type ModalForm struct {
+	Title, Content   string
+	Button1, Button2 string
+
+	// Submit is called with true if the player pressed Button1, or false if they pressed Button2.
+	Submit func(confirmed bool)
+	// Close is called if the player closed the form without pressing either button.
+	Close func()
+}
+
+// marshal ...
+func (f ModalForm) marshal() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"type":    "modal",
+		"title":   f.Title,
+		"content": f.Content,
+		"button1": f.Button1,
+		"button2": f.Button2,
+	})
+}
+
+// submit ...
+func (f ModalForm) submit(response []byte, closed bool) {
+	var confirmed bool
+	if closed || json.Unmarshal(response, &confirmed) != nil {
+		if f.Close != nil {
+			f.Close()
+		}
+		return
+	}
+	if f.Submit != nil {
+		f.Submit(confirmed)
+	}
+}
+
+// Button is a single button of a MenuForm, with optional icon shown next to its text.
+type Button struct {
+	Text string
+	// Image is either a path to a resource pack texture or a URL pointing to an image, and may be left
+	// empty to show no icon at all.
+	Image string
+}
+
+// MenuForm is a form with a title, a body of text and a list of buttons, used to offer the player a choice
+// between one of several options.
+type MenuForm struct {
+	Title, Content string
+	Buttons        []Button
+
+	// Submit is called with the index into Buttons of the button the player pressed.
+	Submit func(index int)
+	// Close is called if the player closed the form without pressing a button.
+	Close func()
+}
+
+// marshal ...
+func (f MenuForm) marshal() ([]byte, error) {
+	buttons := make([]map[string]interface{}, len(f.Buttons))
+	for i, b := range f.Buttons {
+		entry := map[string]interface{}{"text": b.Text}
+		if b.Image != "" {
+			entry["image"] = map[string]interface{}{"type": imageType(b.Image), "data": b.Image}
+		}
+		buttons[i] = entry
+	}
+	return json.Marshal(map[string]interface{}{
+		"type":    "form",
+		"title":   f.Title,
+		"content": f.Content,
+		"buttons": buttons,
+	})
+}
+
+// submit ...
+func (f MenuForm) submit(response []byte, closed bool) {
+	var index int
+	if closed || json.Unmarshal(response, &index) != nil || index < 0 || index >= len(f.Buttons) {
+		if f.Close != nil {
+			f.Close()
+		}
+		return
+	}
+	if f.Submit != nil {
+		f.Submit(index)
+	}
+}
+
+// imageType returns the image type Bedrock expects for a button icon, based on whether path looks like a
+// URL or a resource pack texture path.
+func imageType(path string) string {
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return "url"
+	}
+	return "path"
+}
+
+// Element represents a single element of a CustomForm, such as a label, toggle or slider.
+type Element interface {
+	// encode returns the JSON representation of the element as Bedrock expects it in a custom form's
+	// content array.
+	encode() map[string]interface{}
+	// decode decodes the element's portion of a custom form response into a typed Go value.
+	decode(raw json.RawMessage) (interface{}, error)
+}
+
+// Label is a custom form element that displays static text without accepting any input.
+type Label struct{ Text string }
+
+// encode ...
+func (e Label) encode() map[string]interface{} { return map[string]interface{}{"type": "label", "text": e.Text} }
+
+// decode ...
+func (e Label) decode(json.RawMessage) (interface{}, error) { return nil, nil }
+
+// Input is a custom form element that lets the player type in a line of text.
+type Input struct {
+	Text, Placeholder, Default string
+}
+
+// encode ...
+func (e Input) encode() map[string]interface{} {
+	return map[string]interface{}{"type": "input", "text": e.Text, "placeholder": e.Placeholder, "default": e.Default}
+}
+
+// decode ...
+func (e Input) decode(raw json.RawMessage) (interface{}, error) {
+	var v string
+	return v, json.Unmarshal(raw, &v)
+}
+
+// Toggle is a custom form element that lets the player flip a boolean switch on or off.
+type Toggle struct {
+	Text    string
+	Default bool
+}
+
+// encode ...
+func (e Toggle) encode() map[string]interface{} {
+	return map[string]interface{}{"type": "toggle", "text": e.Text, "default": e.Default}
+}
+
+// decode ...
+func (e Toggle) decode(raw json.RawMessage) (interface{}, error) {
+	var v bool
+	return v, json.Unmarshal(raw, &v)
+}
+
+// Slider is a custom form element that lets the player pick a number between Min and Max, snapped to Step.
+type Slider struct {
+	Text           string
+	Min, Max, Step float64
+	Default        float64
+}
+
+// encode ...
+func (e Slider) encode() map[string]interface{} {
+	return map[string]interface{}{"type": "slider", "text": e.Text, "min": e.Min, "max": e.Max, "step": e.Step, "default": e.Default}
+}
+
+// decode ...
+func (e Slider) decode(raw json.RawMessage) (interface{}, error) {
+	var v float64
+	return v, json.Unmarshal(raw, &v)
+}
+
+// Dropdown is a custom form element that lets the player choose one of Options from a drop-down list.
+type Dropdown struct {
+	Text    string
+	Options []string
+	Default int
+}
+
+// encode ...
+func (e Dropdown) encode() map[string]interface{} {
+	return map[string]interface{}{"type": "dropdown", "text": e.Text, "options": e.Options, "default": e.Default}
+}
+
+// decode ...
+func (e Dropdown) decode(raw json.RawMessage) (interface{}, error) {
+	var v int
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	if v < 0 || v >= len(e.Options) {
+		return nil, fmt.Errorf("dropdown response %v out of range", v)
+	}
+	return e.Options[v], nil
+}
+
+// StepSlider is a custom form element that lets the player cycle through Steps like a slider with discrete
+// text values instead of numbers.
+type StepSlider struct {
+	Text    string
+	Steps   []string
+	Default int
+}
+
+// encode ...
+func (e StepSlider) encode() map[string]interface{} {
+	return map[string]interface{}{"type": "step_slider", "text": e.Text, "steps": e.Steps, "default": e.Default}
+}
+
+// decode ...
+func (e StepSlider) decode(raw json.RawMessage) (interface{}, error) {
+	var v int
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	if v < 0 || v >= len(e.Steps) {
+		return nil, fmt.Errorf("step slider response %v out of range", v)
+	}
+	return e.Steps[v], nil
+}
+
+// CustomForm is a form with a title and a list of Elements, such as labels, toggles and sliders, used to
+// build custom settings menus and input forms.
+type CustomForm struct {
+	Title    string
+	Elements []Element
+
+	// Submit is called with one decoded Go value per entry in Elements, in the same order.
+	Submit func(responses []interface{})
+	// Close is called if the player closed the form without submitting it.
+	Close func()
+}
+
+// marshal ...
+func (f CustomForm) marshal() ([]byte, error) {
+	content := make([]map[string]interface{}, len(f.Elements))
+	for i, e := range f.Elements {
+		content[i] = e.encode()
+	}
+	return json.Marshal(map[string]interface{}{
+		"type":    "custom_form",
+		"title":   f.Title,
+		"content": content,
+	})
+}
+
+// submit ...
+func (f CustomForm) submit(response []byte, closed bool) {
+	fail := func() {
+		if f.Close != nil {
+			f.Close()
+		}
+	}
+	if closed {
+		fail()
+		return
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(response, &raw); err != nil || len(raw) != len(f.Elements) {
+		fail()
+		return
+	}
+	responses := make([]interface{}, len(f.Elements))
+	for i, e := range f.Elements {
+		v, err := e.decode(raw[i])
+		if err != nil {
+			fail()
+			return
+		}
+		responses[i] = v
+	}
+	if f.Submit != nil {
+		f.Submit(responses)
+	}
+}
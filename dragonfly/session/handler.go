@@ -0,0 +1,57 @@
+package session
+
+import "github.com/sandertv/gophertunnel/minecraft/protocol/packet"
+
+// PacketHandler handles a single incoming packet for a Session. Handlers registered for the same
+// packet.ID form a chain, similar to HTTP middleware: each is tried in the order it was registered in
+// until one reports the packet as handled or the chain is exhausted.
+type PacketHandler interface {
+	// Handle processes pk, which was read from the session's connection. Handle may rewrite pk in place
+	// before a later handler in the chain sees it. If the packet should not be processed by any handler
+	// that follows in the chain, handled should be true. A non-nil error stops packet handling entirely and
+	// is returned to the caller of Session.handlePacket.
+	Handle(pk packet.Packet, s *Session) (handled bool, err error)
+}
+
+// PacketHandlerFunc is a function implementing PacketHandler, in the same vein as http.HandlerFunc.
+type PacketHandlerFunc func(pk packet.Packet, s *Session) (handled bool, err error)
+
+// Handle calls f(pk, s).
+func (f PacketHandlerFunc) Handle(pk packet.Packet, s *Session) (bool, error) {
+	return f(pk, s)
+}
+
+// RegisterHandler registers h to be called for every incoming packet with the packet.ID passed. Handlers
+// registered for the same ID are tried in the order they were registered in: the chain stops at the first
+// handler that returns handled = true or a non-nil error. RegisterHandler is safe for concurrent use.
+func (s *Session) RegisterHandler(id uint32, h PacketHandler) {
+	s.handlerMu.Lock()
+	defer s.handlerMu.Unlock()
+	s.handlers[id] = append(s.handlers[id], h)
+}
+
+// InterceptOutgoing registers f to be called for every packet about to be written to the session's
+// connection, in the order such interceptors were registered in. f may rewrite the packet by returning a
+// replacement, or drop it entirely by returning ok = false, in which case it is never sent and later
+// interceptors don't see it. InterceptOutgoing is safe for concurrent use.
+func (s *Session) InterceptOutgoing(f func(pk packet.Packet) (pk2 packet.Packet, ok bool)) {
+	s.handlerMu.Lock()
+	defer s.handlerMu.Unlock()
+	s.outgoingInterceptors = append(s.outgoingInterceptors, f)
+}
+
+// registerDefaultHandlers installs the handlers backing the session's built-in behaviour as fallbacks,
+// run by handlePacket only once every handler registered for that packet.ID through RegisterHandler has
+// had a chance to observe, rewrite or drop the packet. Unlike RegisterHandler, this does not put them in
+// the chain itself, since that would let no plugin handler ever precede them.
+func (s *Session) registerDefaultHandlers() {
+	s.defaultHandlers[packet.IDText] = PacketHandlerFunc(func(pk packet.Packet, s *Session) (bool, error) {
+		return true, s.handleText(pk.(*packet.Text))
+	})
+	s.defaultHandlers[packet.IDCommandRequest] = PacketHandlerFunc(func(pk packet.Packet, s *Session) (bool, error) {
+		return true, s.handleCommandRequest(pk.(*packet.CommandRequest))
+	})
+	s.defaultHandlers[packet.IDModalFormResponse] = PacketHandlerFunc(func(pk packet.Packet, s *Session) (bool, error) {
+		return true, s.handleModalFormResponse(pk.(*packet.ModalFormResponse))
+	})
+}
@@ -0,0 +1,82 @@
+package session
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// clientAddr and serverAddr are synthetic IPv4 addresses used to tell the two ends of a connection apart
+// in pcap captures recorded through a Recorder. They don't need to be real addresses: Wireshark only uses
+// them to group and dissect packets belonging to the same stream.
+var (
+	clientAddr = net.IPv4(127, 0, 0, 1)
+	serverAddr = net.IPv4(243, 0, 0, 2)
+)
+
+// Recorder records raw Minecraft packets to a pcap file, wrapping each one in a synthetic Ethernet/IPv4/UDP
+// frame so that tools such as Wireshark can dissect the capture with existing Bedrock protocol dissectors.
+// A Recorder may be attached to a Session using Session.AttachRecorder and is safe for concurrent use.
+type Recorder struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *pcapgo.Writer
+}
+
+// NewRecorder creates and opens a pcap file at the path passed, writing the pcap file header immediately.
+// The file is truncated if it already exists. The returned Recorder is ready to be passed to
+// Session.AttachRecorder.
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create pcap file: %w", err)
+	}
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("write pcap file header: %w", err)
+	}
+	return &Recorder{f: f, w: w}, nil
+}
+
+// Close flushes and closes the pcap file backing the Recorder. The Recorder must not be used after Close
+// is called.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// write wraps payload, the raw encoded form of a single Minecraft packet, in a fake Ethernet/IPv4/UDP frame
+// travelling from src to dst and appends it to the pcap file, stamped with the current time as its capture
+// time. write is safe for concurrent use.
+func (r *Recorder) write(payload []byte, src, dst net.IP) error {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 0},
+		DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 0},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: src, DstIP: dst}
+	udp := &layers.UDP{SrcPort: 19132, DstPort: 19132}
+	_ = udp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(payload)); err != nil {
+		return fmt.Errorf("serialize capture frame: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.w.WritePacket(gopacket.CaptureInfo{
+		Timestamp:     time.Now(),
+		CaptureLength: len(buf.Bytes()),
+		Length:        len(buf.Bytes()),
+	}, buf.Bytes())
+}
@@ -1,16 +1,18 @@
 package session
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"github.com/dragonfly-tech/dragonfly/dragonfly/cmd"
 	"github.com/dragonfly-tech/dragonfly/dragonfly/player/chat"
 	"github.com/go-gl/mathgl/mgl32"
-	"github.com/sandertv/gophertunnel/minecraft"
-	"github.com/sandertv/gophertunnel/minecraft/cmd"
 	"github.com/sandertv/gophertunnel/minecraft/protocol"
 	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
 	"github.com/sandertv/gophertunnel/minecraft/text"
 	"github.com/sirupsen/logrus"
 	"net"
+	"sync"
 	"sync/atomic"
 )
 
@@ -21,18 +23,43 @@ type Session struct {
 
 	c                  Controllable
 	controllableClosed atomic.Value
-	conn               *minecraft.Conn
+	conn               Conn
 
 	cmdOrigin protocol.CommandOrigin
+
+	// recorder holds the *Recorder currently attached to the session, if any, as an atomic.Value since it
+	// is set through AttachRecorder while being read concurrently by the packet read loop and every
+	// goroutine that writes a packet through writePacket.
+	recorder atomic.Value
+
+	handlerMu            sync.Mutex
+	handlers             map[uint32][]PacketHandler
+	defaultHandlers      map[uint32]PacketHandler
+	outgoingInterceptors []func(pk packet.Packet) (packet.Packet, bool)
+
+	formMu sync.Mutex
+	forms  map[uint32]Form
+	formID uint32
+}
+
+// AttachRecorder attaches a Recorder to the session, causing every packet the session reads from or
+// writes to its connection from this point onward to be captured to the recorder's pcap file. Passing nil
+// detaches any Recorder currently attached. AttachRecorder is safe to call concurrently with the session
+// handling packets.
+func (s *Session) AttachRecorder(r *Recorder) {
+	s.recorder.Store(r)
 }
 
 // New returns a new session using a controllable entity. The session will control this entity using the
 // packets that it receives.
 // New takes the connection from which to accept packets. It will start handling these packets after a call to
-// Session.Handle().
-func New(c Controllable, conn *minecraft.Conn, log *logrus.Logger) *Session {
-	s := &Session{c: c, conn: conn, log: log}
+// Session.Handle(). conn may be backed by RakNet (*minecraft.Conn) or any other transport satisfying Conn,
+// such as the TCP transport returned by ListenTCP.
+func New(c Controllable, conn Conn, log *logrus.Logger) *Session {
+	s := &Session{c: c, conn: conn, log: log, handlers: map[uint32][]PacketHandler{}, defaultHandlers: map[uint32]PacketHandler{}, forms: map[uint32]Form{}}
 	s.controllableClosed.Store(false)
+	s.recorder.Store((*Recorder)(nil))
+	s.registerDefaultHandlers()
 
 	yellow := text.Yellow()
 	chat.Global.Println(yellow(s.conn.IdentityData().DisplayName, "has joined the game"))
@@ -68,6 +95,7 @@ func (s *Session) handlePackets() {
 		if err != nil {
 			return
 		}
+		s.recordPacket(pk, false)
 		if s.controllableClosed.Load().(bool) {
 			// The controllable closed itself, so we need to stop handling packets and close the session.
 			return
@@ -81,20 +109,77 @@ func (s *Session) handlePackets() {
 	}
 }
 
-// handlePacket handles an incoming packet, processing it accordingly. If the packet had invalid data or was
-// otherwise not valid in its context, an error is returned.
+// handlePacket handles an incoming packet, passing it through the chain of PacketHandlers registered for
+// its ID with RegisterHandler. If none of them report the packet as handled, it falls through to the
+// session's built-in handling of that packet ID, if any is registered, so that a plugin handler always gets
+// the chance to observe, rewrite or drop a packet before the built-in logic ever sees it. If nothing
+// handles the packet at all, it is logged and dropped. If the packet had invalid data or was otherwise not
+// valid in its context, an error is returned.
 func (s *Session) handlePacket(pk packet.Packet) error {
-	switch pk := pk.(type) {
-	case *packet.Text:
-		return s.handleText(pk)
-	case *packet.CommandRequest:
-		return s.handleCommandRequest(pk)
-	default:
-		s.log.Debugf("unhandled packet %T%v from %v\n", pk, fmt.Sprintf("%+v", pk)[1:], s.conn.RemoteAddr())
+	s.handlerMu.Lock()
+	handlers := append([]PacketHandler(nil), s.handlers[pk.ID()]...)
+	fallback, ok := s.defaultHandlers[pk.ID()]
+	s.handlerMu.Unlock()
+
+	for _, h := range handlers {
+		handled, err := h.Handle(pk, s)
+		if err != nil {
+			return err
+		}
+		if handled {
+			return nil
+		}
 	}
+	if ok {
+		_, err := fallback.Handle(pk, s)
+		return err
+	}
+	s.log.Debugf("unhandled packet %T%v from %v\n", pk, fmt.Sprintf("%+v", pk)[1:], s.conn.RemoteAddr())
 	return nil
 }
 
+// writePacket writes a packet to the client's connection, first passing it through every interceptor
+// registered with InterceptOutgoing in registration order. An interceptor may rewrite pk or drop it by
+// returning ok = false, in which case writePacket returns immediately without sending anything. The packet
+// actually sent is additionally recorded if a Recorder is attached to the session by way of AttachRecorder.
+func (s *Session) writePacket(pk packet.Packet) error {
+	s.handlerMu.Lock()
+	interceptors := append([]func(packet.Packet) (packet.Packet, bool){}, s.outgoingInterceptors...)
+	s.handlerMu.Unlock()
+
+	for _, intercept := range interceptors {
+		ok := false
+		if pk, ok = intercept(pk); !ok {
+			return nil
+		}
+	}
+
+	s.recordPacket(pk, true)
+	return s.conn.WritePacket(pk)
+}
+
+// recordPacket encodes pk the same way it would be sent or was received over the connection and passes it
+// to the session's Recorder, if any is attached. outgoing indicates the direction the packet travelled in:
+// true for packets written to the client, false for packets read from it.
+func (s *Session) recordPacket(pk packet.Packet, outgoing bool) {
+	rec, _ := s.recorder.Load().(*Recorder)
+	if rec == nil {
+		return
+	}
+	buf := bytes.NewBuffer(nil)
+	header := &packet.Header{PacketID: pk.ID()}
+	_ = header.Write(buf)
+	pk.Marshal(protocol.NewWriter(buf, 0))
+
+	src, dst := clientAddr, serverAddr
+	if outgoing {
+		src, dst = serverAddr, clientAddr
+	}
+	if err := rec.write(buf.Bytes(), src, dst); err != nil {
+		s.log.Errorf("error recording packet %T: %v\n", pk, err)
+	}
+}
+
 // handleText ...
 func (s *Session) handleText(pk *packet.Text) error {
 	if pk.TextType != packet.TextTypeChat {
@@ -119,7 +204,7 @@ func (s *Session) handleCommandRequest(pk *packet.CommandRequest) error {
 
 // SendMessage ...
 func (s *Session) SendMessage(message string) {
-	_ = s.conn.WritePacket(&packet.Text{
+	_ = s.writePacket(&packet.Text{
 		TextType: packet.TextTypeRaw,
 		Message:  message,
 	})
@@ -127,7 +212,7 @@ func (s *Session) SendMessage(message string) {
 
 // SendTip ...
 func (s *Session) SendTip(message string) {
-	_ = s.conn.WritePacket(&packet.Text{
+	_ = s.writePacket(&packet.Text{
 		TextType: packet.TextTypePopup,
 		Message:  message,
 	})
@@ -135,7 +220,7 @@ func (s *Session) SendTip(message string) {
 
 // SendAnnouncement ...
 func (s *Session) SendAnnouncement(message string) {
-	_ = s.conn.WritePacket(&packet.Text{
+	_ = s.writePacket(&packet.Text{
 		TextType: packet.TextTypeAnnouncement,
 		Message:  message,
 	})
@@ -143,7 +228,7 @@ func (s *Session) SendAnnouncement(message string) {
 
 // SendPopup ...
 func (s *Session) SendPopup(message string) {
-	_ = s.conn.WritePacket(&packet.Text{
+	_ = s.writePacket(&packet.Text{
 		TextType: packet.TextTypePopup,
 		Message:  message,
 	})
@@ -151,7 +236,7 @@ func (s *Session) SendPopup(message string) {
 
 // SendJukeBoxPopup ...
 func (s *Session) SendJukeBoxPopup(message string) {
-	_ = s.conn.WritePacket(&packet.Text{
+	_ = s.writePacket(&packet.Text{
 		TextType: packet.TextTypeJukeboxPopup,
 		Message:  message,
 	})
@@ -159,7 +244,7 @@ func (s *Session) SendJukeBoxPopup(message string) {
 
 // SendTitle ...
 func (s *Session) SendTitle(text string, fadeInDuration int32, remainDuration int32, fadeOutDuration int32){
-	_ = s.conn.WritePacket(&packet.SetTitle{
+	_ = s.writePacket(&packet.SetTitle{
 		ActionType:      packet.TitleActionSetTitle,
 		Text:            text,
 		FadeInDuration:  fadeInDuration,
@@ -170,7 +255,7 @@ func (s *Session) SendTitle(text string, fadeInDuration int32, remainDuration in
 
 // SendSubTitle ...
 func (s *Session) SendSubTitle(text string, fadeInDuration int32, remainDuration int32, fadeOutDuration int32) {
-	_ = s.conn.WritePacket(&packet.SetTitle{
+	_ = s.writePacket(&packet.SetTitle{
 		ActionType:      packet.TitleActionSetSubtitle,
 		Text:            text,
 		FadeInDuration:  fadeInDuration,
@@ -181,7 +266,7 @@ func (s *Session) SendSubTitle(text string, fadeInDuration int32, remainDuration
 
 // SendActionbarMessage ...
 func (s *Session) SendActionBarMessage(text string, fadeInDuration int32, remainDuration int32, fadeOutDuration int32) {
-	_ = s.conn.WritePacket(&packet.SetTitle{
+	_ = s.writePacket(&packet.SetTitle{
 		ActionType:      packet.TitleActionSetActionBar,
 		Text:            text,
 		FadeInDuration:  fadeInDuration,
@@ -192,7 +277,7 @@ func (s *Session) SendActionBarMessage(text string, fadeInDuration int32, remain
 
 // SendNetherDimension sends the player to the nether dimension
 func (s *Session) SendNetherDimension(){
-	_ = s.conn.WritePacket(&packet.ChangeDimension{
+	_ = s.writePacket(&packet.ChangeDimension{
 		Dimension: packet.DimensionNether,
 		Position:  mgl32.Vec3{},
 		Respawn:   false,
@@ -201,7 +286,7 @@ func (s *Session) SendNetherDimension(){
 
 // SendEndDimension sends the player to the end dimension
 func (s *Session) SendEndDimension(){
-	_ = s.conn.WritePacket(&packet.ChangeDimension{
+	_ = s.writePacket(&packet.ChangeDimension{
 		Dimension: packet.DimensionEnd,
 		Position:  mgl32.Vec3{},
 		Respawn:   false,
@@ -210,7 +295,7 @@ func (s *Session) SendEndDimension(){
 
 // SendNetherDimension sends the player to the overworld dimension
 func (s *Session) SendOverworldDimension(){
-	_ = s.conn.WritePacket(&packet.ChangeDimension{
+	_ = s.writePacket(&packet.ChangeDimension{
 		Dimension: packet.DimensionOverworld,
 		Position:  mgl32.Vec3{},
 		Respawn:   false,
@@ -220,7 +305,7 @@ func (s *Session) SendOverworldDimension(){
 // Disconnect disconnects the client and ultimately closes the session. If the message passed is non-empty,
 // it will be shown to the client.
 func (s *Session) Disconnect(message string) {
-	_ = s.conn.WritePacket(&packet.Disconnect{
+	_ = s.writePacket(&packet.Disconnect{
 		HideDisconnectionScreen: message == "",
 		Message:                 message,
 	})
@@ -229,34 +314,48 @@ func (s *Session) Disconnect(message string) {
 
 // Transfer transfers the player to a server with the IP and port passed.
 func (s *Session) Transfer(ip net.IP, port int) {
-	_ = s.conn.WritePacket(&packet.Transfer{
+	_ = s.writePacket(&packet.Transfer{
 		Address: ip.String(),
 		Port:    uint16(port),
 	})
 }
 
 // SendCommandOutput sends the output of a command to the player. It will be shown to the caller of the
-// command, which might be the player or a websocket server.
+// command, which might be the player, a command block or a websocket server. SuccessCount reflects the
+// number of sub-executions of the command that actually succeeded, rather than the number of messages
+// printed, which matters for commands such as /execute that may run many times in one invocation.
 func (s *Session) SendCommandOutput(output *cmd.Output) {
-	messages := make([]protocol.CommandOutputMessage, 0, output.MessageCount()+output.ErrorCount())
-	for _, message := range output.Messages() {
-		messages = append(messages, protocol.CommandOutputMessage{
-			Success: true,
-			Message: message,
-		})
+	outputMessages := make([]protocol.CommandOutputMessage, len(output.Messages()))
+	for i, m := range output.Messages() {
+		outputMessages[i] = protocol.CommandOutputMessage{
+			Success:    m.Success,
+			Message:    m.Message,
+			Parameters: m.Parameters,
+		}
 	}
-	for _, err := range output.Errors() {
-		messages = append(messages, protocol.CommandOutputMessage{
-			Success: false,
-			Message: err.Error(),
-		})
+
+	outputType := byte(packet.CommandOutputTypeAllOutput)
+	var dataSet string
+	if origin := s.cmdOrigin.Origin; origin == protocol.CommandOriginAutomationPlayer || origin == protocol.CommandOriginDedicatedServer {
+		// Automation and websocket command origins expect a machine-readable data set rather than a list
+		// of human-readable messages, so that the caller can inspect the result of each sub-execution.
+		// gophertunnel only writes the DataSet field when OutputType is CommandOutputTypeDataSet, so the
+		// JSON goes there rather than into OutputMessages.
+		outputType = packet.CommandOutputTypeDataSet
+		data, err := json.Marshal(outputMessages)
+		if err != nil {
+			s.log.Errorf("error encoding command output data set: %v\n", err)
+			data = []byte("[]")
+		}
+		dataSet = string(data)
 	}
 
-	_ = s.conn.WritePacket(&packet.CommandOutput{
+	_ = s.writePacket(&packet.CommandOutput{
 		CommandOrigin:  s.cmdOrigin,
-		OutputType:     3,
-		SuccessCount:   uint32(output.MessageCount()),
-		OutputMessages: messages,
+		OutputType:     outputType,
+		SuccessCount:   uint32(output.SuccessCount()),
+		OutputMessages: outputMessages,
+		DataSet:        dataSet,
 	})
 }
 
@@ -293,7 +392,7 @@ func (s *Session) SendAvailableCommands() {
 			Overloads:   overloads,
 		})
 	}
-	_ = s.conn.WritePacket(pk)
+	_ = s.writePacket(pk)
 }
 
 // valueToParamType finds the command argument type of a value passed and returns it, in addition to creating
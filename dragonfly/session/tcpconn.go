@@ -0,0 +1,245 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"github.com/sandertv/gophertunnel/minecraft/protocol/login"
+	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
+)
+
+// packetPool holds a constructor for every packet ID known to gophertunnel, used by tcpConn.ReadPacket to
+// allocate the right concrete packet.Packet for an incoming frame based on the ID decoded from its header.
+var packetPool = packet.NewPool()
+
+// maxFrameLength is the largest frame payload readFrame will allocate a buffer for. A length prefix above
+// this is treated as malformed input rather than honoured as-is, since the prefix is attacker-controlled
+// and nothing gophertunnel sends approaches this size.
+const maxFrameLength = 8 * 1024 * 1024
+
+// tcpConn is a Conn implementation backed by a plain TCP connection rather than a RakNet connection. It is
+// used when Dragonfly runs behind a Bedrock proxy that has already terminated the client's RakNet
+// connection, so that the proxy-to-server hop can use cheap framed TCP instead of another RakNet
+// connection.
+type tcpConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	writeMu sync.Mutex
+
+	identity login.IdentityData
+}
+
+// ListenTCP listens for TCP connections on the address passed and performs the ConnectionRequest/
+// ConnectionResponse handshake on each one as it comes in. Successfully handshaked connections are sent on
+// the returned channel as Conns ready to be passed into New. Closing the returned io.Closer stops the
+// listener, closes the channel, and releases any connection that finished its handshake but is still
+// waiting to be read from the channel, so a slow or absent consumer can never pin goroutines or sockets
+// open.
+func ListenTCP(addr string) (<-chan Conn, io.Closer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listen tcp: %w", err)
+	}
+	conns := make(chan Conn)
+	closer := &tcpListenCloser{l: l, done: make(chan struct{})}
+	go func() {
+		defer close(conns)
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					if r := recover(); r != nil {
+						_ = c.Close()
+					}
+				}()
+				conn, err := acceptTCP(c)
+				if err != nil {
+					_ = c.Close()
+					return
+				}
+				select {
+				case conns <- conn:
+				case <-closer.done:
+					_ = conn.Close()
+				}
+			}()
+		}
+	}()
+	return conns, closer, nil
+}
+
+// tcpListenCloser stops a ListenTCP listener: it closes the underlying net.Listener, ending the accept
+// loop, and closes done so that accepted connections blocked trying to hand themselves off on the conns
+// channel are released instead of leaking their goroutine and socket.
+type tcpListenCloser struct {
+	l    net.Listener
+	done chan struct{}
+	once sync.Once
+}
+
+// Close ...
+func (c *tcpListenCloser) Close() error {
+	c.once.Do(func() { close(c.done) })
+	return c.l.Close()
+}
+
+// acceptTCP performs the ConnectionRequest/ConnectionResponse handshake on c, reading the identity data the
+// remote end sends and returning a Conn wrapping c once the handshake has completed.
+func acceptTCP(c net.Conn) (Conn, error) {
+	t := &tcpConn{conn: c, r: bufio.NewReader(c)}
+
+	frame, err := t.readFrame()
+	if err != nil {
+		return nil, fmt.Errorf("read connection request: %w", err)
+	}
+	req := connectionRequest{}
+	if err := safeUnmarshal(func() { req.Unmarshal(protocol.NewReader(bytes.NewBuffer(frame), 0)) }); err != nil {
+		return nil, fmt.Errorf("decode connection request: %w", err)
+	}
+	t.identity = login.IdentityData{XUID: req.XUID, Identity: req.UUID, DisplayName: req.DisplayName}
+
+	buf := bytes.NewBuffer(nil)
+	(&connectionResponse{}).Marshal(protocol.NewWriter(buf, 0))
+	if err := t.writeFrame(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("write connection response: %w", err)
+	}
+	return t, nil
+}
+
+// safeUnmarshal calls decode, recovering from any panic it raises and turning it into an error instead.
+// protocol.Reader panics on malformed or truncated input, and unlike *minecraft.Conn, tcpConn has no other
+// layer recovering from that, so every decode reachable from untrusted input goes through safeUnmarshal.
+func safeUnmarshal(decode func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic decoding packet: %v", r)
+		}
+	}()
+	decode()
+	return nil
+}
+
+// connectionRequest is sent by the proxy right after dialing a tcpConn, carrying the identity of the player
+// the proxy is forwarding so that the backend server doesn't need to redo the login handshake.
+type connectionRequest struct {
+	XUID        string
+	UUID        string
+	DisplayName string
+	Skin        []byte
+}
+
+// Marshal ...
+func (r *connectionRequest) Marshal(w *protocol.Writer) {
+	w.String(&r.XUID)
+	w.String(&r.UUID)
+	w.String(&r.DisplayName)
+	w.ByteSlice(&r.Skin)
+}
+
+// Unmarshal ...
+func (r *connectionRequest) Unmarshal(rd *protocol.Reader) {
+	rd.String(&r.XUID)
+	rd.String(&r.UUID)
+	rd.String(&r.DisplayName)
+	rd.ByteSlice(&r.Skin)
+}
+
+// connectionResponse is sent back over a tcpConn once the backend server accepted the connectionRequest
+// that preceded it.
+type connectionResponse struct{}
+
+// Marshal ...
+func (r *connectionResponse) Marshal(*protocol.Writer) {}
+
+// Unmarshal ...
+func (r *connectionResponse) Unmarshal(*protocol.Reader) {}
+
+// ReadPacket reads the next packet frame from the TCP connection and decodes it using the packet pool.
+func (t *tcpConn) ReadPacket() (packet.Packet, error) {
+	frame, err := t.readFrame()
+	if err != nil {
+		return nil, fmt.Errorf("read packet frame: %w", err)
+	}
+	buf := bytes.NewBuffer(frame)
+	header := &packet.Header{}
+	if err := header.Read(buf); err != nil {
+		return nil, fmt.Errorf("read packet header: %w", err)
+	}
+	pkFunc, ok := packetPool[header.PacketID]
+	if !ok {
+		return nil, fmt.Errorf("unknown packet id %v", header.PacketID)
+	}
+	pk := pkFunc()
+	if err := safeUnmarshal(func() { pk.Unmarshal(protocol.NewReader(buf, 0)) }); err != nil {
+		return nil, fmt.Errorf("decode packet: %w", err)
+	}
+	return pk, nil
+}
+
+// WritePacket encodes pk and writes it to the TCP connection as a single length-prefixed frame.
+func (t *tcpConn) WritePacket(pk packet.Packet) error {
+	buf := bytes.NewBuffer(nil)
+	header := &packet.Header{PacketID: pk.ID()}
+	if err := header.Write(buf); err != nil {
+		return fmt.Errorf("write packet header: %w", err)
+	}
+	pk.Marshal(protocol.NewWriter(buf, 0))
+	return t.writeFrame(buf.Bytes())
+}
+
+// IdentityData returns the identity data the remote end sent as part of the connection handshake.
+func (t *tcpConn) IdentityData() login.IdentityData {
+	return t.identity
+}
+
+// RemoteAddr returns the remote network address of the underlying TCP connection.
+func (t *tcpConn) RemoteAddr() net.Addr {
+	return t.conn.RemoteAddr()
+}
+
+// Close closes the underlying TCP connection.
+func (t *tcpConn) Close() error {
+	return t.conn.Close()
+}
+
+// writeFrame writes payload to the connection prefixed with its length as a 4 byte big endian integer.
+// Session may call WritePacket from multiple goroutines at once (chat, command execution, the packet read
+// loop), so writeFrame is guarded by a mutex and writes the length and payload as a single buffered write
+// to make sure concurrent frames can never interleave on the wire.
+func (t *tcpConn) writeFrame(payload []byte) error {
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err := t.conn.Write(frame)
+	return err
+}
+
+// readFrame reads a single length-prefixed frame from the connection and returns its payload.
+func (t *tcpConn) readFrame() ([]byte, error) {
+	var lengthBuf [4]byte
+	if _, err := io.ReadFull(t.r, lengthBuf[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(lengthBuf[:])
+	if length > maxFrameLength {
+		return nil, fmt.Errorf("frame length %v exceeds maximum of %v", length, maxFrameLength)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(t.r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}